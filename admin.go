@@ -0,0 +1,38 @@
+package main
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// handleAdminStats serves /_csswaf/admin/stats: a JSON snapshot of the
+// current blocklist state, guarded by a bearer token (-admin-token). If no
+// token is configured, the endpoint is disabled (404) rather than exposed
+// unauthenticated.
+func (waf *CSSWAF) handleAdminStats(w http.ResponseWriter, r *http.Request) {
+	if waf.adminToken == "" {
+		http.NotFound(w, r)
+		return
+	}
+
+	token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	if !ok || subtle.ConstantTimeCompare([]byte(token), []byte(waf.adminToken)) != 1 {
+		http.Error(w, "Unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if waf.blocklist == nil {
+		_, _ = w.Write([]byte(`{"blocklist_enabled":false}`))
+		return
+	}
+	_ = json.NewEncoder(w).Encode(struct {
+		BlocklistEnabled bool               `json:"blocklist_enabled"`
+		IPs              map[string]ipState `json:"ips"`
+	}{
+		BlocklistEnabled: true,
+		IPs:              waf.blocklist.Snapshot(),
+	})
+}
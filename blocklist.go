@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"log/slog"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BlocklistOptions configures a Blocklist. See the -block-* flags in
+// main.go for where these come from.
+type BlocklistOptions struct {
+	// Threshold is the number of failed challenges/honeypot hits within
+	// Window that blocks an IP.
+	Threshold int
+	// Window is the fixed window over which failures are counted: once a
+	// failure lands more than Window after the first failure that opened
+	// the current window, the count resets and a new window starts.
+	Window time.Duration
+	// Duration is how long an IP stays blocked once Threshold is crossed.
+	Duration time.Duration
+	// Allowlist exempts these CIDRs from ever being blocked.
+	Allowlist []*net.IPNet
+	// TrustXFF makes ClientIP prefer X-Forwarded-For/X-Real-IP over
+	// RemoteAddr; only enable this behind a trusted reverse proxy.
+	TrustXFF bool
+}
+
+// ipState is the per-IP bookkeeping Blocklist keeps: a fixed-window failure
+// count, and an optional block expiry.
+type ipState struct {
+	FailureCount int       `json:"failure_count"`
+	WindowStart  time.Time `json:"window_start"`
+	BlockedUntil time.Time `json:"blocked_until"`
+}
+
+// Blocklist counts failed challenges and honeypot hits per client IP in a
+// fixed window and, once a threshold is crossed, blocks that IP for a
+// cooldown period. It is shared across all of a CSSWAF's routes, since
+// blocking is a network-level decision independent of which host an
+// attacker happens to be probing.
+type Blocklist struct {
+	opts BlocklistOptions
+
+	mu    sync.Mutex
+	state map[string]*ipState
+}
+
+// NewBlocklist creates an empty Blocklist.
+func NewBlocklist(opts BlocklistOptions) *Blocklist {
+	return &Blocklist{
+		opts:  opts,
+		state: make(map[string]*ipState),
+	}
+}
+
+// ClientIP extracts the client IP from r, honoring TrustXFF.
+func (b *Blocklist) ClientIP(r *http.Request) string {
+	if b.opts.TrustXFF {
+		if xff := r.Header.Get("X-Forwarded-For"); xff != "" {
+			if i := strings.IndexByte(xff, ','); i >= 0 {
+				xff = xff[:i]
+			}
+			if ip := strings.TrimSpace(xff); ip != "" {
+				return ip
+			}
+		}
+		if xri := strings.TrimSpace(r.Header.Get("X-Real-IP")); xri != "" {
+			return xri
+		}
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		return host
+	}
+	return r.RemoteAddr
+}
+
+// allowlisted reports whether ip falls inside one of the configured
+// allowlist CIDRs.
+func (b *Blocklist) allowlisted(ip string) bool {
+	parsed := net.ParseIP(ip)
+	if parsed == nil {
+		return false
+	}
+	for _, cidr := range b.opts.Allowlist {
+		if cidr.Contains(parsed) {
+			return true
+		}
+	}
+	return false
+}
+
+// RecordFailure counts a failed challenge or honeypot hit from ip, blocking
+// it for opts.Duration once opts.Threshold failures land inside
+// opts.Window.
+func (b *Blocklist) RecordFailure(ip string) {
+	if b.allowlisted(ip) {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	b.evictExpired(now)
+
+	s, ok := b.state[ip]
+	if !ok || now.Sub(s.WindowStart) > b.opts.Window {
+		s = &ipState{WindowStart: now}
+		b.state[ip] = s
+	}
+	s.FailureCount++
+
+	if s.FailureCount >= b.opts.Threshold {
+		s.BlockedUntil = now.Add(b.opts.Duration)
+		slog.Warn("Blocking IP after repeated failures",
+			"ip", ip,
+			"failures", s.FailureCount,
+			"until", s.BlockedUntil,
+		)
+	}
+}
+
+// evictExpired drops state for any IP whose failure window has lapsed and
+// whose block, if any, has also expired, so a flood of distinct IPs (e.g.
+// spoofed via -trust-xff's X-Forwarded-For) can't grow b.state without
+// bound. Callers must hold b.mu.
+func (b *Blocklist) evictExpired(now time.Time) {
+	for ip, s := range b.state {
+		if now.Sub(s.WindowStart) > b.opts.Window && !now.Before(s.BlockedUntil) {
+			delete(b.state, ip)
+		}
+	}
+}
+
+// Blocked reports whether ip is currently serving a block.
+func (b *Blocklist) Blocked(ip string) bool {
+	if b.allowlisted(ip) {
+		return false
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s, ok := b.state[ip]
+	if !ok || s.BlockedUntil.IsZero() {
+		return false
+	}
+	if time.Now().After(s.BlockedUntil) {
+		return false
+	}
+	return true
+}
+
+// Snapshot returns a copy of the current per-IP state, for the admin stats
+// endpoint.
+func (b *Blocklist) Snapshot() map[string]ipState {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	out := make(map[string]ipState, len(b.state))
+	for ip, s := range b.state {
+		out[ip] = *s
+	}
+	return out
+}
+
+// SaveToFile persists the blocklist state as JSON, so a restart doesn't
+// grant every tracked IP amnesty.
+func (b *Blocklist) SaveToFile(path string) error {
+	b.mu.Lock()
+	b.evictExpired(time.Now())
+	data, err := json.Marshal(b.state)
+	b.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(path, data, 0o600)
+}
+
+// LoadFromFile restores previously persisted blocklist state. A missing
+// file is not an error (first run).
+func (b *Blocklist) LoadFromFile(path string) error {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var state map[string]*ipState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return err
+	}
+	if state == nil {
+		state = make(map[string]*ipState)
+	}
+
+	b.mu.Lock()
+	b.state = state
+	b.mu.Unlock()
+	return nil
+}
+
+// ParseAllowlist parses a comma-separated list of CIDRs (or bare IPs, which
+// are treated as a /32 or /128) for -block-allowlist.
+func ParseAllowlist(s string) ([]*net.IPNet, error) {
+	var nets []*net.IPNet
+	for _, entry := range strings.Split(s, ",") {
+		entry = strings.TrimSpace(entry)
+		if entry == "" {
+			continue
+		}
+		if !strings.Contains(entry, "/") {
+			if ip := net.ParseIP(entry); ip != nil && ip.To4() != nil {
+				entry += "/32"
+			} else {
+				entry += "/128"
+			}
+		}
+		_, cidr, err := net.ParseCIDR(entry)
+		if err != nil {
+			return nil, err
+		}
+		nets = append(nets, cidr)
+	}
+	return nets, nil
+}
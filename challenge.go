@@ -0,0 +1,740 @@
+package main
+
+import (
+	"crypto/subtle"
+	_ "embed"
+	"log/slog"
+	mathrand "math/rand/v2"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Challenge is a pluggable no-JS client verification strategy. Each
+// implementation decides how to prove a request came from a real browser
+// (CSS keyframes, prefers-reduced-motion, :hover/:focus, <picture> srcset,
+// ...) and owns whatever state and asset paths it needs under /_csswaf/.
+// CSSKeyframesChallenge below is the original (and so far only) strategy.
+type Challenge interface {
+	// Render writes the full challenge response for a freshly generated
+	// sessionID.
+	Render(w http.ResponseWriter, r *http.Request, sessionID string)
+
+	// HandleAsset serves a request under the /_csswaf/ prefix that this
+	// challenge owns (tracking images, status images, ...). It reports
+	// whether the path was recognized; an unrecognized path should be
+	// treated as 404 by the caller.
+	HandleAsset(w http.ResponseWriter, r *http.Request) bool
+
+	// Validated reports whether the caller's own cookies represent a
+	// completed challenge, and the sessionID it was issued to.
+	Validated(r *http.Request) (sessionID string, ok bool)
+
+	// ActiveSessions reports how many sessions this challenge currently
+	// considers validated, for the csswaf_sessions_active gauge.
+	ActiveSessions() int
+}
+
+//go:embed empty.gif
+var emptyGIF []byte
+
+//go:embed pensive.webp
+var pensivewebp []byte
+
+//go:embed happy.webp
+var happywebp []byte
+
+//go:embed sad.webp
+var sadwebp []byte
+
+// CSSKeyframesChallengeOptions carries the per-host tunables for a
+// CSSKeyframesChallenge; the zero value of any field falls back to the
+// package defaults applied by NewCSSKeyframesChallenge.
+type CSSKeyframesChallengeOptions struct {
+	SequenceLength      int
+	Honeypot            []string
+	CSSAnimationTS      float64
+	ShowSessionStatusTS float64
+	PageRefreshTS       float64
+
+	// Blocklist, if set, is notified of honeypot hits and failed
+	// (mismatched) sequences so repeat offenders get rate-limited.
+	Blocklist *Blocklist
+
+	// Metrics, if set, records challenge/honeypot/image-load counters and
+	// the issue-to-validation histogram.
+	Metrics *Metrics
+
+	// TrustXFF makes isTLS honor X-Forwarded-Proto; only enable this behind
+	// a trusted, TLS-terminating reverse proxy. Should match the -trust-xff
+	// value given to Blocklist.
+	TrustXFF bool
+}
+
+// CSSKeyframesChallenge is the original CSSWAF challenge: it hides a
+// `@keyframes` animation that loads tracking images in a server-chosen
+// order, decoys it with a honeypot image only a scraper would fetch, and
+// considers a session human once the images load back in the right order.
+// All progress is carried in the signed csswaf_challenge cookie (see
+// [ChallengeSigner]); the server holds no per-session state.
+type CSSKeyframesChallenge struct {
+	signer         *ChallengeSigner
+	cookieName     string
+	cookieLifetime time.Duration
+
+	sequence            []string
+	honeypot            []string
+	cssAnimationTS      float64
+	showSessionStatusTS float64
+	pageRefreshTS       float64
+
+	blocklist *Blocklist
+	metrics   *Metrics
+	trustXFF  bool
+}
+
+// NewCSSKeyframesChallenge creates a CSSKeyframesChallenge. secretKey seals
+// and verifies its cookies; pass the same key across restarts so in-flight
+// challenges survive a redeploy.
+func NewCSSKeyframesChallenge(secretKey []byte, ttl time.Duration, opts CSSKeyframesChallengeOptions) *CSSKeyframesChallenge {
+	sequenceLength := opts.SequenceLength
+	if sequenceLength <= 0 {
+		sequenceLength = 6
+	}
+	honeypot := opts.Honeypot
+	if honeypot == nil {
+		honeypot = []string{"G.html", "H.txt", "I.sitemap", "J.xml", "article", "content", "user", "history", "O", "P", "Q"}
+	}
+	cssAnimationTS := opts.CSSAnimationTS
+	if cssAnimationTS <= 0 {
+		cssAnimationTS = 3.5
+	}
+	showSessionStatusTS := opts.ShowSessionStatusTS
+	if showSessionStatusTS <= 0 {
+		showSessionStatusTS = 4.0
+	}
+	pageRefreshTS := opts.PageRefreshTS
+	if pageRefreshTS <= 0 {
+		pageRefreshTS = 5.5
+	}
+
+	sequence := sequenceOfLength(sequenceLength)
+	honeypot = excludeSequenceCollisions(sequence, honeypot)
+
+	return &CSSKeyframesChallenge{
+		signer:              NewChallengeSigner(secretKey, ttl),
+		cookieName:          "csswaf_session",
+		cookieLifetime:      1 * time.Hour,
+		sequence:            sequence,
+		honeypot:            honeypot,
+		cssAnimationTS:      cssAnimationTS,
+		showSessionStatusTS: showSessionStatusTS,
+		pageRefreshTS:       pageRefreshTS,
+		blocklist:           opts.Blocklist,
+		metrics:             opts.Metrics,
+		trustXFF:            opts.TrustXFF,
+	}
+}
+
+// sequenceOfLength returns {"A", "B", "C", ...} of the given length.
+func sequenceOfLength(n int) []string {
+	seq := make([]string, n)
+	for i := range seq {
+		seq[i] = string(rune('A' + i))
+	}
+	return seq
+}
+
+// excludeSequenceCollisions drops any honeypot entries that collide with one
+// of the sequence's image IDs. A honeypot entry that is also a legitimate
+// sequence position would always look like a honeypot hit, making that
+// position -- and so the whole challenge -- permanently unvalidatable.
+func excludeSequenceCollisions(sequence, honeypot []string) []string {
+	filtered := make([]string, 0, len(honeypot))
+	for _, h := range honeypot {
+		if slices.Contains(sequence, h) {
+			slog.Warn("Ignoring honeypot entry that collides with the challenge sequence", "image", h)
+			continue
+		}
+		filtered = append(filtered, h)
+	}
+	return filtered
+}
+
+// shuffle returns a shuffled copy of the input slice.
+func shuffle(input []string) []string {
+	perm := mathrand.Perm(len(input))
+	for i, v := range perm {
+		input[v], input[i] = input[i], input[v]
+	}
+	return input
+}
+
+// sidMatches reports whether the sid query parameter double-submitted on a
+// tracking request matches the sessionID sealed inside the caller's own
+// cookie. This is what makes the /_csswaf/img and sessionstatus.webp
+// endpoints resistant to forgery from an off-origin page that merely knows
+// (or guesses) another user's sid: without that user's HttpOnly, SameSite
+// cookie also being attached, the two values won't agree.
+func sidMatches(sid, sessionID string) bool {
+	return len(sid) == len(sessionID) && subtle.ConstantTimeCompare([]byte(sid), []byte(sessionID)) == 1
+}
+
+// isTLS reports whether the incoming request arrived over HTTPS, directly
+// or, if trustXFF is set, via a trusted TLS-terminating proxy. It honors the
+// same trust boundary as Blocklist.ClientIP: X-Forwarded-Proto is otherwise
+// attacker-controlled and must not be trusted unless -trust-xff says so.
+func (c *CSSKeyframesChallenge) isTLS(r *http.Request) bool {
+	return r.TLS != nil || (c.trustXFF && r.Header.Get("X-Forwarded-Proto") == "https")
+}
+
+// setChallengeCookie issues the csswaf_challenge cookie. It is scoped
+// SameSite=Strict: it is only ever needed for same-origin tracking image
+// requests generated by our own challenge page, so it should never be sent
+// on a cross-site request at all.
+func (c *CSSKeyframesChallenge) setChallengeCookie(w http.ResponseWriter, r *http.Request, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     challengeCookieName,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   c.isTLS(r),
+		SameSite: http.SameSiteStrictMode,
+		Expires:  time.Now().Add(c.cookieLifetime),
+	})
+}
+
+// setSessionCookie issues the csswaf_session cookie. SameSite=Lax so that it
+// is still attached to the top-level navigations the proxied site depends
+// on, while still being withheld from cross-site subresource requests.
+func (c *CSSKeyframesChallenge) setSessionCookie(w http.ResponseWriter, r *http.Request, value string) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     c.cookieName,
+		Value:    value,
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   c.isTLS(r),
+		SameSite: http.SameSiteLaxMode,
+		Expires:  time.Now().Add(c.cookieLifetime),
+	})
+}
+
+// clearChallengeCookie invalidates the csswaf_challenge cookie on the
+// client, e.g. after a honeypot hit or a sequence mismatch.
+func (c *CSSKeyframesChallenge) clearChallengeCookie(w http.ResponseWriter, r *http.Request) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     challengeCookieName,
+		Value:    "",
+		Path:     "/",
+		HttpOnly: true,
+		Secure:   c.isTLS(r),
+		SameSite: http.SameSiteStrictMode,
+		MaxAge:   -1,
+	})
+}
+
+// writeTrackingPixel serves the tiny transparent 1x1 pixel GIF used by all
+// tracking image endpoints.
+func writeTrackingPixel(w http.ResponseWriter) {
+	w.Header().Set("Content-Type", "image/gif")
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
+	_, _ = w.Write(emptyGIF)
+}
+
+// handleImageRequest processes requests for our tracking images. All
+// challenge state lives in the signed csswaf_challenge cookie, so this
+// reconstructs the expected sequence from the cookie rather than from any
+// server-side session store.
+func (c *CSSKeyframesChallenge) handleImageRequest(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(r.URL.Path, "/")
+	if len(parts) < 4 {
+		http.NotFound(w, r)
+		return
+	}
+
+	imageID := parts[len(parts)-1]
+
+	// Get session ID from query parameter. Reject anything shorter than the
+	// sessionID prefix we log below before it's ever sliced, since sid is
+	// attacker-controlled and a short value would otherwise panic.
+	sessionID := r.URL.Query().Get("sid")
+	if len(sessionID) < 8 {
+		http.NotFound(w, r)
+		return
+	}
+
+	challengeCookie, err := r.Cookie(challengeCookieName)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+	state, err := c.signer.Open(challengeCookie.Value)
+	if err != nil {
+		slog.Warn("Rejected challenge cookie", "sessionID", sessionID[:8], "error", err)
+		http.NotFound(w, r)
+		return
+	}
+	if !sidMatches(sessionID, state.SessionID) {
+		slog.Warn("sid does not match challenge cookie, rejecting", "sessionID", sessionID[:8])
+		http.NotFound(w, r)
+		return
+	}
+
+	if slices.Contains(c.honeypot, imageID) {
+		viaLink := r.URL.Query().Get("via") == "link"
+		slog.Warn("Honeypot image loaded",
+			"sessionID", sessionID[:8],
+			"imageID", imageID,
+			"viaLink", viaLink,
+		)
+		// The honeypot is rendered two ways: a loading="lazy" <img>, which a
+		// browser that doesn't honor the lazy hint (rather than a bot) can
+		// fetch on an otherwise ordinary page render, and a display:none <a
+		// class="honeya">, which nothing short of a scraper following every
+		// link will ever follow. Only the latter is a reliable bot signal,
+		// so only it counts towards the IP blocklist.
+		if viaLink && c.blocklist != nil {
+			c.blocklist.RecordFailure(c.blocklist.ClientIP(r))
+		}
+		c.metrics.IncHoneypotHit(imageID)
+		c.clearChallengeCookie(w, r)
+		writeTrackingPixel(w)
+		return
+	}
+
+	c.metrics.IncImageLoad(imageID)
+	received := append(state.Received, imageID)
+	slog.Info("Image loaded",
+		"sessionID", sessionID[:8],
+		"imageID", imageID,
+		"sequence", received,
+	)
+
+	if len(received) > len(state.Expected) || !slices.Equal(received, state.Expected[:len(received)]) {
+		slog.Info("Session validation result",
+			"sessionID", sessionID[:8],
+			"validated", false,
+			"expected", state.Expected,
+			"received", received,
+		)
+		if c.blocklist != nil {
+			c.blocklist.RecordFailure(c.blocklist.ClientIP(r))
+		}
+		c.clearChallengeCookie(w, r)
+		writeTrackingPixel(w)
+		return
+	}
+
+	if len(received) == len(state.Expected) {
+		// Full, matching sequence: promote to a validated session cookie,
+		// but only once per challenge nonce so a replayed final image load
+		// can't mint a second session.
+		if c.signer.MarkValidated(state.Nonce) {
+			sessionToken, err := c.signer.SealSession(sessionID, time.Now().Add(c.cookieLifetime))
+			if err != nil {
+				slog.Error("Failed to seal session cookie", "error", err)
+			} else {
+				c.setSessionCookie(w, r, sessionToken)
+			}
+			c.metrics.IncChallengesValidated()
+			c.metrics.ObserveIssueToValidation(time.Since(time.Unix(state.IssuedAt, 0)))
+		}
+		slog.Info("Session validation result",
+			"sessionID", sessionID[:8],
+			"validated", true,
+			"expected", state.Expected,
+			"received", received,
+		)
+		c.clearChallengeCookie(w, r)
+	} else {
+		state.Received = received
+		token, err := c.signer.Seal(state)
+		if err != nil {
+			slog.Error("Failed to reseal challenge cookie", "error", err)
+		} else {
+			c.setChallengeCookie(w, r, token)
+		}
+	}
+
+	writeTrackingPixel(w)
+	slog.Info("Served tracking image",
+		"sessionID", sessionID[:8],
+		"imageID", imageID,
+	)
+}
+
+var filemap = map[string][]byte{
+	"pensive.webp": pensivewebp,
+	"happy.webp":   happywebp,
+	"sad.webp":     sadwebp,
+}
+
+// handleSessionStatus serves /_csswaf/res/sessionstatus.webp, which shows
+// happy.webp or sad.webp depending on whether the caller's session cookie
+// has been validated.
+func (c *CSSKeyframesChallenge) handleSessionStatus(w http.ResponseWriter, r *http.Request) {
+	validated := false
+	if cookie, err := r.Cookie(c.cookieName); err == nil {
+		sessionID, ok := c.signer.OpenSession(cookie.Value)
+		if ok {
+			if !sidMatches(r.URL.Query().Get("sid"), sessionID) {
+				// sid doesn't match this caller's own signed session
+				// cookie; refuse to answer rather than leak another
+				// session's status.
+				http.NotFound(w, r)
+				return
+			}
+			validated = true
+		}
+	}
+
+	w.Header().Set("Content-Type", "image/webp")
+	// no-cache
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+
+	if validated {
+		w.Header().Set("Content-Length", strconv.Itoa(len(happywebp)))
+		_, _ = w.Write(happywebp)
+	} else {
+		w.Header().Set("Content-Length", strconv.Itoa(len(sadwebp)))
+		_, _ = w.Write(sadwebp)
+	}
+}
+
+// HandleAsset implements Challenge.
+func (c *CSSKeyframesChallenge) HandleAsset(w http.ResponseWriter, r *http.Request) bool {
+	if strings.HasPrefix(r.URL.Path, "/_csswaf/res/") {
+		fileName := r.URL.Path[len("/_csswaf/res/"):]
+		if fileName == "sessionstatus.webp" {
+			c.handleSessionStatus(w, r)
+			return true
+		}
+		if data, exists := filemap[fileName]; exists {
+			w.Header().Set("Content-Type", "image/webp")
+			w.Header().Set("Cache-Control", "public, max-age=31536000")
+			w.Header().Set("Content-Length", strconv.Itoa(len(data)))
+			_, _ = w.Write(data)
+			return true
+		}
+		http.NotFound(w, r)
+		return true
+	}
+	if strings.HasPrefix(r.URL.Path, "/_csswaf/img/") {
+		c.handleImageRequest(w, r)
+		return true
+	}
+	return false
+}
+
+// Validated implements Challenge.
+func (c *CSSKeyframesChallenge) Validated(r *http.Request) (sessionID string, ok bool) {
+	cookie, err := r.Cookie(c.cookieName)
+	if err != nil {
+		return "", false
+	}
+	return c.signer.OpenSession(cookie.Value)
+}
+
+// ActiveSessions implements Challenge.
+func (c *CSSKeyframesChallenge) ActiveSessions() int {
+	return c.signer.ActiveSessions()
+}
+
+// Render implements Challenge.
+func (c *CSSKeyframesChallenge) Render(w http.ResponseWriter, r *http.Request, sessionID string) {
+	nonce, err := NewNonce()
+	if err != nil {
+		slog.Error("Failed to generate challenge nonce", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+
+	// random reordering
+	expectedSequence := shuffle(slices.Clone(c.sequence))
+
+	challengeToken, err := c.signer.Seal(&ChallengeState{
+		SessionID: sessionID,
+		Expected:  expectedSequence,
+		IssuedAt:  time.Now().Unix(),
+		Nonce:     nonce,
+	})
+	if err != nil {
+		slog.Error("Failed to seal challenge cookie", "error", err)
+		http.Error(w, "Internal Server Error", http.StatusInternalServerError)
+		return
+	}
+	c.setChallengeCookie(w, r, challengeToken)
+	c.metrics.IncChallengesIssued()
+	slog.Info("Set expected sequence for session",
+		"sessionID", sessionID[:8],
+		"sequence", expectedSequence,
+	)
+
+	newBody := `<!DOCTYPE html>
+<html>
+<head>
+<link rel="icon" href="data:image/png;base64,iVBORw0KGgo="> <!-- empty favicon to prevent browser requests -->
+<meta http-equiv="refresh" content="` + strconv.FormatFloat(c.pageRefreshTS, 'f', -1, 64) + `">
+<style>
+.honeypot {` + func() string {
+		lines := []string{}
+		for _, img := range c.honeypot {
+			lines = append(lines, "content: url('/_csswaf/img/"+img+"?sid="+sessionID+"');")
+			break // TEST: only one honeypot css image
+		}
+		return strings.Join(lines, "\n")
+	}() + `
+}
+@keyframes csswaf-load {
+  ` + func(expectedSequence []string) string {
+		lines := []string{}
+		for i, img := range expectedSequence {
+			f := float64(i) / float64(len(expectedSequence))
+			lines = append(lines, strconv.Itoa(int(f*100))+`% { content: url('/_csswaf/img/`+img+`?sid=`+sessionID+`'); }`)
+		}
+		lines = shuffle(lines)
+		return strings.Join(lines, "\n")
+	}(expectedSequence) + `
+}
+.csswaf-hidden {
+width: 1px;
+height: 1px;
+position: absolute;
+top: 0px;
+left: 0px;
+animation: csswaf-load ` + strconv.FormatFloat(c.cssAnimationTS, 'f', -1, 64) + `s linear infinite;
+}
+
+/* center the content */
+body {
+display: flex;
+justify-content: center;
+align-items: center;
+height: 100vh;
+margin: 0;
+font-family: Arial, sans-serif;
+background-color: #f9f5d7;
+}
+
+.container {
+text-align: center;
+}
+
+/* copied from anubis */
+.lds-roller,
+.lds-roller div,
+.lds-roller div:after {
+	box-sizing: border-box;
+}
+
+.lds-roller {
+	display: inline-block;
+	position: relative;
+	width: 80px;
+	height: 80px;
+}
+
+.lds-roller div {
+	animation: lds-roller 1.2s cubic-bezier(0.5, 0, 0.5, 1) infinite;
+	transform-origin: 40px 40px;
+}
+
+.lds-roller div:after {
+	content: " ";
+	display: block;
+	position: absolute;
+	width: 7.2px;
+	height: 7.2px;
+	border-radius: 50%;
+	background: currentColor;
+	margin: -3.6px 0 0 -3.6px;
+}
+
+.lds-roller div:nth-child(1) {
+	animation-delay: -0.036s;
+}
+
+.lds-roller div:nth-child(1):after {
+	top: 62.62742px;
+	left: 62.62742px;
+}
+
+.lds-roller div:nth-child(2) {
+	animation-delay: -0.072s;
+}
+
+.lds-roller div:nth-child(2):after {
+	top: 67.71281px;
+	left: 56px;
+}
+
+.lds-roller div:nth-child(3) {
+	animation-delay: -0.108s;
+}
+
+.lds-roller div:nth-child(3):after {
+	top: 70.90963px;
+	left: 48.28221px;
+}
+
+.lds-roller div:nth-child(4) {
+	animation-delay: -0.144s;
+}
+
+.lds-roller div:nth-child(4):after {
+	top: 72px;
+	left: 40px;
+}
+
+.lds-roller div:nth-child(5) {
+	animation-delay: -0.18s;
+}
+
+.lds-roller div:nth-child(5):after {
+	top: 70.90963px;
+	left: 31.71779px;
+}
+
+.lds-roller div:nth-child(6) {
+	animation-delay: -0.216s;
+}
+
+.lds-roller div:nth-child(6):after {
+	top: 67.71281px;
+	left: 24px;
+}
+
+.lds-roller div:nth-child(7) {
+	animation-delay: -0.252s;
+}
+
+.lds-roller div:nth-child(7):after {
+	top: 62.62742px;
+	left: 17.37258px;
+}
+
+.lds-roller div:nth-child(8) {
+	animation-delay: -0.288s;
+}
+
+.lds-roller div:nth-child(8):after {
+	top: 56px;
+	left: 12.28719px;
+}
+
+@keyframes lds-roller {
+	0% {
+	transform: rotate(0deg);
+	}
+
+	100% {
+	transform: rotate(360deg);
+	}
+}
+
+
+.message {
+	font-size: 18px;
+	color: #333;
+	margin-top: 10px;
+}
+
+/* Image switching animation */
+
+.pensive {
+	animation: show-pensive ` + strconv.FormatFloat(c.showSessionStatusTS, 'f', -1, 64) + `s steps(1, end) forwards;
+}
+
+.mysession {
+	animation: show-mysession ` + strconv.FormatFloat(c.showSessionStatusTS, 'f', -1, 64) + `s steps(1, end) forwards;
+	opacity: 0; /* hide initially */
+}
+
+@keyframes show-pensive {
+	0% {
+		opacity: 1;
+		content: url('/_csswaf/res/pensive.webp');
+	}
+	100% {
+		opacity: 0;
+	}
+}
+
+@keyframes show-mysession {
+	0% {
+		opacity: 0;
+	}
+	100% {
+		opacity: 1;
+		content: url('/_csswaf/res/sessionstatus.webp?sid=` + sessionID + `');
+	}
+}
+.honeya {
+	display: none;
+	width: 0px;
+	height: 0px;
+	position: absolute;
+	top: -99px;
+	left: -99px;
+}
+  </style>
+</head>
+<body>
+` + func() string {
+		lines := []string{}
+		for _, img := range c.honeypot {
+			lines = append(lines, "<a href='/_csswaf/img/"+img+"?sid="+sessionID+"&via=link' class='honeya'>View Content</a>")
+		}
+		return strings.Join(lines, "\n")
+	}() + `
+<div class="csswaf-hidden"></div>
+<div class="container">
+	<div class="pensive"></div>
+	<div class="mysession"></div>
+	<p class="message">...</p>
+	<div id="spinner" class="lds-roller">
+		<div></div>
+		<div></div>
+		<div></div>
+		<div></div>
+		<div></div>
+		<div></div>
+		<div></div>
+		<div></div>
+	</div>` + func() string {
+		lines := []string{}
+		for _, img := range shuffle(slices.Clone(c.honeypot)) {
+			// put the honeypot to unseen positions, enable lazy loading.
+			// If user loads the honeypot, BOOM! It's a bot.
+			lines = append(lines, `<img src="/_csswaf/img/`+img+`?sid=`+sessionID+`" style="width: 0px; height: 0px; position: absolute; top: -9999px; left: -9999px;" loading="lazy">`)
+		}
+		return strings.Join(lines, "\n")
+	}() + `
+	<p class="message">Challenge: please wait for ` + strconv.FormatFloat(c.pageRefreshTS, 'f', -1, 64) + ` seconds</p>
+	<p class="message">This Challenge is NoJS friendly</p>
+	<p class="message">Session ID: ` + sessionID + `</p>
+	<footer>
+		<p>Powered by <a href="https://github.com/yzqzss/csswaf">CSSWAF</a></p>
+	</footer>
+</div>
+</body>
+</html>`
+
+	w.Header().Set("Content-Type", "text/html")
+	w.Header().Set("Content-Length", strconv.Itoa(len(newBody)))
+	// no-cache
+	w.Header().Set("Cache-Control", "no-store, no-cache, must-revalidate")
+	w.Header().Set("Pragma", "no-cache")
+
+	// 403 Forbidden
+	w.WriteHeader(http.StatusForbidden)
+	_, _ = w.Write([]byte(newBody))
+
+	slog.Info("Injected CSS challenge",
+		"sessionID", sessionID[:8],
+		"contentLength", len(newBody),
+	)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Config is the top-level shape of the -config YAML file: one entry per
+// origin CSSWAF fronts.
+type Config struct {
+	Hosts []HostConfig `yaml:"hosts"`
+}
+
+// HostConfig configures a single Route. Host is matched against the
+// incoming request's Host header (port stripped); leave it empty to define
+// the fallback route used for any Host that doesn't match another entry.
+type HostConfig struct {
+	Host   string `yaml:"host"`
+	Target string `yaml:"target"`
+
+	SequenceLength      int      `yaml:"sequence_length"`
+	Honeypot            []string `yaml:"honeypot"`
+	CSSAnimationTS      float64  `yaml:"css_animation_ts"`
+	ShowSessionStatusTS float64  `yaml:"show_session_status_ts"`
+	PageRefreshTS       float64  `yaml:"page_refresh_ts"`
+
+	BypassRules []Rule `yaml:"bypass_rules"`
+}
+
+// LoadConfig reads and parses the -config YAML file.
+func LoadConfig(path string) (*Config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var cfg Config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("parsing %s: %w", path, err)
+	}
+	return &cfg, nil
+}
+
+// BuildRouter turns a parsed Config into a Router, sealing each host's
+// challenge cookies with secretKey and bounding cookie/nonce lifetime by
+// ttl. Hosts without explicit bypass_rules get defaultBypassRules. blocklist
+// and metrics (either may be nil) are shared by every host's challenge.
+// trustXFF should match the -trust-xff value given to blocklist.
+func (cfg *Config) BuildRouter(secretKey []byte, ttl time.Duration, blocklist *Blocklist, metrics *Metrics, trustXFF bool) (*Router, error) {
+	routes := make(map[string]*Route, len(cfg.Hosts))
+	for _, hc := range cfg.Hosts {
+		bypassRules := hc.BypassRules
+		if bypassRules == nil {
+			bypassRules = defaultBypassRules
+		}
+
+		challenge := NewCSSKeyframesChallenge(secretKey, ttl, CSSKeyframesChallengeOptions{
+			SequenceLength:      hc.SequenceLength,
+			Honeypot:            hc.Honeypot,
+			CSSAnimationTS:      hc.CSSAnimationTS,
+			ShowSessionStatusTS: hc.ShowSessionStatusTS,
+			PageRefreshTS:       hc.PageRefreshTS,
+			Blocklist:           blocklist,
+			Metrics:             metrics,
+			TrustXFF:            trustXFF,
+		})
+
+		route, err := NewRoute(hc.Target, challenge, bypassRules, metrics)
+		if err != nil {
+			return nil, fmt.Errorf("host %q: %w", hc.Host, err)
+		}
+		routes[hc.Host] = route
+	}
+	return NewRouter(routes), nil
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Metrics collects the counters, gauges, and histograms CSSWAF exposes at
+// /_csswaf/metrics in the Prometheus text exposition format. It is hand
+// rolled against the stdlib only -- there's no need for the full
+// client_golang dependency just to format a few numbers as text.
+type Metrics struct {
+	mu sync.Mutex
+
+	challengesIssued    uint64
+	challengesValidated uint64
+	honeypotHits        map[string]uint64
+	imageLoads          map[string]uint64
+
+	// activeSessions reports a gauge value for csswaf_sessions_active. It is
+	// typically wired to a ChallengeSigner's validated-nonce cache length.
+	activeSessions func() float64
+
+	issueToValidation *Histogram
+	proxyLatency      *Histogram
+}
+
+// NewMetrics creates an empty Metrics. activeSessions may be nil, in which
+// case csswaf_sessions_active is omitted.
+func NewMetrics(activeSessions func() float64) *Metrics {
+	return &Metrics{
+		honeypotHits:   make(map[string]uint64),
+		imageLoads:     make(map[string]uint64),
+		activeSessions: activeSessions,
+		// Buckets are seconds; issue-to-validation tracks around
+		// pageRefreshTS (a few seconds), proxy latency around typical
+		// upstream response times.
+		issueToValidation: NewHistogram([]float64{0.5, 1, 2, 3, 5, 8, 13, 21}),
+		proxyLatency:      NewHistogram([]float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2, 5}),
+	}
+}
+
+// IncChallengesIssued records a new challenge page having been rendered.
+func (m *Metrics) IncChallengesIssued() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.challengesIssued++
+	m.mu.Unlock()
+}
+
+// IncChallengesValidated records a session completing its challenge.
+func (m *Metrics) IncChallengesValidated() {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.challengesValidated++
+	m.mu.Unlock()
+}
+
+// IncHoneypotHit records a honeypot image being fetched.
+func (m *Metrics) IncHoneypotHit(image string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.honeypotHits[image]++
+	m.mu.Unlock()
+}
+
+// IncImageLoad records a legitimate sequence image being fetched.
+func (m *Metrics) IncImageLoad(image string) {
+	if m == nil {
+		return
+	}
+	m.mu.Lock()
+	m.imageLoads[image]++
+	m.mu.Unlock()
+}
+
+// ObserveIssueToValidation records how long it took a session to go from
+// challenge issuance to full validation.
+func (m *Metrics) ObserveIssueToValidation(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.issueToValidation.Observe(d.Seconds())
+}
+
+// ObserveProxyLatency records how long an upstream proxy round trip took.
+func (m *Metrics) ObserveProxyLatency(d time.Duration) {
+	if m == nil {
+		return
+	}
+	m.proxyLatency.Observe(d.Seconds())
+}
+
+// ServeHTTP implements http.Handler, writing the current metrics in
+// Prometheus text exposition format.
+func (m *Metrics) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	fmt.Fprint(w, "# TYPE csswaf_challenges_issued_total counter\n")
+	fmt.Fprintf(w, "csswaf_challenges_issued_total %d\n", m.challengesIssued)
+
+	fmt.Fprint(w, "# TYPE csswaf_challenges_validated_total counter\n")
+	fmt.Fprintf(w, "csswaf_challenges_validated_total %d\n", m.challengesValidated)
+
+	fmt.Fprint(w, "# TYPE csswaf_honeypot_hits_total counter\n")
+	for _, image := range sortedKeys(m.honeypotHits) {
+		fmt.Fprintf(w, "csswaf_honeypot_hits_total{image=%q} %d\n", image, m.honeypotHits[image])
+	}
+
+	fmt.Fprint(w, "# TYPE csswaf_image_loads_total counter\n")
+	for _, image := range sortedKeys(m.imageLoads) {
+		fmt.Fprintf(w, "csswaf_image_loads_total{image=%q} %d\n", image, m.imageLoads[image])
+	}
+
+	if m.activeSessions != nil {
+		fmt.Fprint(w, "# TYPE csswaf_sessions_active gauge\n")
+		fmt.Fprintf(w, "csswaf_sessions_active %g\n", m.activeSessions())
+	}
+
+	m.issueToValidation.WriteTo(w, "csswaf_challenge_issue_to_validation_seconds")
+	m.proxyLatency.WriteTo(w, "csswaf_proxy_latency_seconds")
+}
+
+func sortedKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// Histogram is a minimal Prometheus-style cumulative histogram over a fixed
+// set of buckets.
+type Histogram struct {
+	mu      sync.Mutex
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	total   uint64
+}
+
+// NewHistogram creates a Histogram with the given (ascending) bucket upper
+// bounds.
+func NewHistogram(buckets []float64) *Histogram {
+	return &Histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+// Observe records v into every bucket it falls under.
+func (h *Histogram) Observe(v float64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.sum += v
+	h.total++
+	for i, b := range h.buckets {
+		if v <= b {
+			h.counts[i]++
+		}
+	}
+}
+
+// WriteTo writes h in Prometheus histogram exposition format under name.
+func (h *Histogram) WriteTo(w io.Writer, name string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	fmt.Fprintf(w, "# TYPE %s histogram\n", name)
+	for i, b := range h.buckets {
+		fmt.Fprintf(w, "%s_bucket{le=%q} %d\n", name, strconv.FormatFloat(b, 'g', -1, 64), h.counts[i])
+	}
+	fmt.Fprintf(w, "%s_bucket{le=\"+Inf\"} %d\n", name, h.total)
+	fmt.Fprintf(w, "%s_sum %g\n", name, h.sum)
+	fmt.Fprintf(w, "%s_count %d\n", name, h.total)
+}
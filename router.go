@@ -0,0 +1,156 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// Rule is a single bypass condition evaluated against an incoming request;
+// a Route is bypassed (proxied straight through, no challenge) if any of
+// its rules match. See defaultBypassRules for the rules CSSWAF has always
+// applied.
+type Rule struct {
+	// Type is one of "user-agent-not-contains", "path-contains", or
+	// "path-suffix".
+	Type  string `yaml:"type"`
+	Value string `yaml:"value"`
+}
+
+// Matches reports whether r describes req.
+func (rule Rule) Matches(req *http.Request) bool {
+	switch rule.Type {
+	case "user-agent-not-contains":
+		return !strings.Contains(req.Header.Get("User-Agent"), rule.Value)
+	case "path-contains":
+		return strings.Contains(strings.ToLower(req.URL.Path), strings.ToLower(rule.Value))
+	case "path-suffix":
+		return strings.HasSuffix(strings.ToLower(req.URL.Path), strings.ToLower(rule.Value))
+	default:
+		return false
+	}
+}
+
+// defaultBypassRules reproduces CSSWAF's original, hard-coded bypass
+// behavior: non-browser user agents, RSS/Atom readers, and plain text
+// fetches all skip the challenge and go straight to the upstream.
+var defaultBypassRules = []Rule{
+	{Type: "user-agent-not-contains", Value: "Mozilla"},
+	{Type: "path-contains", Value: "rss"},
+	{Type: "path-contains", Value: "feed"},
+	{Type: "path-contains", Value: "atom"},
+	{Type: "path-suffix", Value: ".txt"},
+}
+
+// Route is everything CSSWAF needs to handle traffic for one origin: where
+// to proxy validated requests, which Challenge guards them, and which
+// requests bypass the challenge entirely.
+type Route struct {
+	Target      *url.URL
+	Proxy       *httputil.ReverseProxy
+	Challenge   Challenge
+	BypassRules []Rule
+}
+
+// NewRoute builds a Route that reverse-proxies to targetURL behind
+// challenge. metrics (may be nil) records upstream proxy latency.
+func NewRoute(targetURL string, challenge Challenge, bypassRules []Rule, metrics *Metrics) (*Route, error) {
+	target, err := url.Parse(targetURL)
+	if err != nil {
+		return nil, err
+	}
+
+	proxy := httputil.NewSingleHostReverseProxy(target)
+	originalDirector := proxy.Director
+	proxy.Director = func(req *http.Request) {
+		originalDirector(req)
+		req.Host = target.Host
+	}
+	proxy.Transport = &timingTransport{next: http.DefaultTransport, metrics: metrics}
+
+	return &Route{
+		Target:      target,
+		Proxy:       proxy,
+		Challenge:   challenge,
+		BypassRules: bypassRules,
+	}, nil
+}
+
+// timingTransport wraps an http.RoundTripper to record upstream proxy
+// latency into csswaf_proxy_latency_seconds.
+type timingTransport struct {
+	next    http.RoundTripper
+	metrics *Metrics
+}
+
+func (t *timingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	start := time.Now()
+	resp, err := t.next.RoundTrip(req)
+	t.metrics.ObserveProxyLatency(time.Since(start))
+	return resp, err
+}
+
+// Bypassed reports whether req should skip the challenge for this route.
+func (route *Route) Bypassed(req *http.Request) bool {
+	for _, rule := range route.BypassRules {
+		if rule.Matches(req) {
+			return true
+		}
+	}
+	return false
+}
+
+// Router maps a request's Host header (and, for hosts configured with a
+// path prefix, its URL path too) to the Route that should handle it.
+type Router struct {
+	byHost   map[string]*Route
+	fallback *Route
+}
+
+// NewRouter builds a Router from host -> Route. If "" is present in routes,
+// it is used as the fallback for unmatched hosts (the -target/-bind
+// single-host shortcut always registers one this way).
+func NewRouter(routes map[string]*Route) *Router {
+	rt := &Router{byHost: make(map[string]*Route, len(routes))}
+	for host, route := range routes {
+		if host == "" {
+			rt.fallback = route
+			continue
+		}
+		rt.byHost[host] = route
+	}
+	return rt
+}
+
+// Match returns the Route that should handle req, or nil if there is none.
+func (rt *Router) Match(req *http.Request) *Route {
+	host := req.Host
+	if i := strings.IndexByte(host, ':'); i >= 0 {
+		host = host[:i]
+	}
+	if route, ok := rt.byHost[host]; ok {
+		return route
+	}
+	return rt.fallback
+}
+
+// ActiveSessions sums ActiveSessions across every distinct Route's
+// Challenge, for the csswaf_sessions_active gauge.
+func (rt *Router) ActiveSessions() int {
+	seen := make(map[*Route]bool, len(rt.byHost)+1)
+	total := 0
+	add := func(route *Route) {
+		if route == nil || seen[route] {
+			return
+		}
+		seen[route] = true
+		total += route.Challenge.ActiveSessions()
+	}
+	for _, route := range rt.byHost {
+		add(route)
+	}
+	add(rt.fallback)
+	return total
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"crypto/hmac"
+	cryptorand "crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/gob"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/jellydator/ttlcache/v3"
+)
+
+// challengeCookieName carries the signed, stateless challenge progress.
+// challengeCookieName and the csswaf_session cookie (see CSSWAF.cookieName)
+// are the only session state CSSWAF keeps; neither requires server-side
+// storage keyed by session ID, so any number of CSSWAF instances can share
+// traffic for the same origin without sticky sessions.
+const challengeCookieName = "csswaf_challenge"
+
+// ErrInvalidChallenge is returned when a sealed cookie fails signature
+// verification, has expired, or is otherwise malformed.
+var ErrInvalidChallenge = errors.New("csswaf: invalid or expired challenge cookie")
+
+// ChallengeState is the payload sealed inside the csswaf_challenge cookie.
+// It carries everything needed to validate the next image load without any
+// server-side session storage.
+type ChallengeState struct {
+	SessionID string
+	Expected  []string
+	Received  []string
+	IssuedAt  int64
+	Nonce     [16]byte
+}
+
+// ChallengeSigner seals and verifies ChallengeState values (and the
+// "validated" session marker) with HMAC-SHA256, and remembers nonces that
+// have already completed validation so a captured challenge cookie can't be
+// replayed to mint a second session cookie.
+type ChallengeSigner struct {
+	key        []byte
+	ttl        time.Duration
+	usedNonces *ttlcache.Cache[[16]byte, struct{}]
+}
+
+// NewChallengeSigner creates a signer. key should be kept secret and stable
+// across restarts (see -secret-key); ttl bounds how long a challenge or
+// session cookie is accepted after issuance.
+func NewChallengeSigner(key []byte, ttl time.Duration) *ChallengeSigner {
+	usedNonces := ttlcache.New(
+		ttlcache.WithTTL[[16]byte, struct{}](ttl),
+		ttlcache.WithDisableTouchOnHit[[16]byte, struct{}](),
+	)
+	go usedNonces.Start()
+
+	return &ChallengeSigner{
+		key:        key,
+		ttl:        ttl,
+		usedNonces: usedNonces,
+	}
+}
+
+// NewNonce generates a random nonce to uniquely identify a challenge instance.
+func NewNonce() ([16]byte, error) {
+	var n [16]byte
+	_, err := cryptorand.Read(n[:])
+	return n, err
+}
+
+// sign returns the HMAC-SHA256 tag for payload.
+func (s *ChallengeSigner) sign(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.key)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// Seal gob-encodes and signs state into a cookie-safe string of the form
+// "<payload>.<signature>", both base64url-encoded.
+func (s *ChallengeSigner) Seal(state *ChallengeState) (string, error) {
+	var buf bytes.Buffer
+	if err := gob.NewEncoder(&buf).Encode(state); err != nil {
+		return "", err
+	}
+	payload := buf.Bytes()
+	sig := s.sign(payload)
+	return base64.URLEncoding.EncodeToString(payload) + "." + base64.URLEncoding.EncodeToString(sig), nil
+}
+
+// Open verifies the signature and expiry of a sealed challenge cookie value
+// and decodes the enclosed state.
+func (s *ChallengeSigner) Open(value string) (*ChallengeState, error) {
+	payload, sig, ok := splitSealed(value)
+	if !ok || !hmac.Equal(sig, s.sign(payload)) {
+		return nil, ErrInvalidChallenge
+	}
+
+	var state ChallengeState
+	if err := gob.NewDecoder(bytes.NewReader(payload)).Decode(&state); err != nil {
+		return nil, ErrInvalidChallenge
+	}
+	if time.Since(time.Unix(state.IssuedAt, 0)) > s.ttl {
+		return nil, ErrInvalidChallenge
+	}
+	return &state, nil
+}
+
+// ActiveSessions returns the number of distinct nonces currently remembered
+// as validated, i.e. roughly the number of sessions that passed their
+// challenge within the last ttl. Exposed as the csswaf_sessions_active
+// gauge, since -- unlike the old SessionTracker -- there is no longer a
+// server-side map keyed by session ID to report the length of.
+func (s *ChallengeSigner) ActiveSessions() int {
+	return s.usedNonces.Len()
+}
+
+// MarkValidated records that a challenge nonce has completed validation. It
+// returns false if the nonce was already marked, so a resent (replayed)
+// "fully matched" challenge cookie cannot mint a second session cookie.
+func (s *ChallengeSigner) MarkValidated(nonce [16]byte) bool {
+	if s.usedNonces.Get(nonce) != nil {
+		return false
+	}
+	s.usedNonces.Set(nonce, struct{}{}, ttlcache.DefaultTTL)
+	return true
+}
+
+// SealSession issues a signed "validated" marker for sessionID, to be stored
+// in the csswaf_session cookie. Unlike the challenge cookie, its payload is
+// plain text (sessionID is not secret) but still HMAC-signed so it cannot be
+// forged by a client that only knows its own session ID.
+func (s *ChallengeSigner) SealSession(sessionID string, expiry time.Time) (string, error) {
+	payload := []byte(sessionID + "|" + strconv.FormatInt(expiry.Unix(), 10))
+	sig := s.sign(payload)
+	return base64.URLEncoding.EncodeToString(payload) + "." + base64.URLEncoding.EncodeToString(sig), nil
+}
+
+// OpenSession verifies a csswaf_session cookie value and returns the
+// sessionID it was issued for, and whether it is valid and unexpired.
+func (s *ChallengeSigner) OpenSession(value string) (sessionID string, ok bool) {
+	payload, sig, ok := splitSealed(value)
+	if !ok || !hmac.Equal(sig, s.sign(payload)) {
+		return "", false
+	}
+
+	sep := bytes.LastIndexByte(payload, '|')
+	if sep < 0 {
+		return "", false
+	}
+	expiryUnix, err := strconv.ParseInt(string(payload[sep+1:]), 10, 64)
+	if err != nil || time.Now().After(time.Unix(expiryUnix, 0)) {
+		return "", false
+	}
+	return string(payload[:sep]), true
+}
+
+// splitSealed decodes a "<payload>.<signature>" cookie value into its raw
+// parts without verifying the signature.
+func splitSealed(value string) (payload, sig []byte, ok bool) {
+	parts := strings.SplitN(value, ".", 2)
+	if len(parts) != 2 {
+		return nil, nil, false
+	}
+	payload, err := base64.URLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, nil, false
+	}
+	sig, err = base64.URLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, nil, false
+	}
+	return payload, sig, true
+}